@@ -8,16 +8,181 @@ package golang
 import (
 	"fmt"
 	"go/build"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 )
 
+// KnownGOARCH is the list of GOARCH values recognized by the Go toolchain,
+// taken from the canonical list in cmd/dist/build.go.
+var KnownGOARCH = []string{
+	"386", "amd64", "arm", "arm64", "loong64", "mips", "mipsle", "mips64",
+	"mips64le", "ppc64", "ppc64le", "riscv64", "s390x", "wasm",
+}
+
+// KnownGOOS is the list of GOOS values recognized by the Go toolchain, taken
+// from the canonical list in cmd/dist/build.go.
+var KnownGOOS = []string{
+	"darwin", "dragonfly", "illumos", "ios", "js", "wasip1", "linux",
+	"android", "solaris", "freebsd", "netbsd", "openbsd", "plan9", "windows",
+	"aix",
+}
+
+// invalidGOOSGOARCH are GOOS/GOARCH pairs that appear in KnownGOOS and
+// KnownGOARCH individually, but that the Go toolchain does not actually
+// support combined.
+var invalidGOOSGOARCH = map[string]bool{
+	"windows/loong64": true,
+	"plan9/arm64":     true,
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks that c.GOOS and c.GOARCH (when set) appear in KnownGOOS
+// and KnownGOARCH and that their pair is not in invalidGOOSGOARCH, so that
+// cross-compilation typos fail fast with a helpful message instead of
+// invoking the toolchain and waiting for it to die. Unsupported pairs that
+// are not in invalidGOOSGOARCH (e.g. js/amd64) pass Validate.
+func (c Environ) Validate() error {
+	if c.GOARCH != "" && !contains(KnownGOARCH, c.GOARCH) {
+		return fmt.Errorf("unsupported GOARCH %q", c.GOARCH)
+	}
+	if c.GOOS != "" && !contains(KnownGOOS, c.GOOS) {
+		return fmt.Errorf("unsupported GOOS %q", c.GOOS)
+	}
+	if c.GOOS != "" && c.GOARCH != "" && invalidGOOSGOARCH[c.GOOS+"/"+c.GOARCH] {
+		return fmt.Errorf("unsupported GOOS/GOARCH pair %s/%s", c.GOOS, c.GOARCH)
+	}
+	return nil
+}
+
 type Environ struct {
 	build.Context
 
 	GO111MODULE string
+
+	// GOARM and GOMIPS select the ARM or MIPS architecture variant to build
+	// for, when GOARCH is "arm", "mips", or "mipsle". Empty means the Go
+	// toolchain's default.
+	GOARM  string
+	GOMIPS string
+
+	// MinVersion is the minimum Go toolchain version required to build
+	// with this Environ, checked by CheckVersion. The zero value disables
+	// the check.
+	MinVersion GoVersion
+}
+
+// GoVersion is a parsed Go toolchain version, e.g. {Major: 1, Minor: 21} for
+// go1.21.4.
+type GoVersion struct {
+	Major int
+	Minor int
+}
+
+// String returns the canonical "goMAJOR.MINOR" representation of v.
+func (v GoVersion) String() string {
+	return fmt.Sprintf("go%d.%d", v.Major, v.Minor)
+}
+
+// Less reports whether v is an older version than other.
+func (v GoVersion) Less(other GoVersion) bool {
+	if v.Major != other.Major {
+		return v.Major < other.Major
+	}
+	return v.Minor < other.Minor
+}
+
+// parseGoVersion parses a version string as returned by Environ.Version,
+// e.g. a release tag like "go1.21.4", or a development string like "devel
+// go1.22-abcdef" or the literal "gotip". The bool return reports whether v
+// names a development toolchain, which CheckVersion always treats as
+// satisfying any MinVersion.
+func parseGoVersion(v string) (GoVersion, bool, error) {
+	if v == "gotip" {
+		return GoVersion{}, true, nil
+	}
+
+	s := v
+	devel := false
+	if rest, ok := strings.CutPrefix(s, "devel "); ok {
+		devel = true
+		s = rest
+	}
+	s = strings.TrimPrefix(s, "go")
+	if s == v {
+		return GoVersion{}, false, fmt.Errorf("unrecognized go version string: %q", v)
+	}
+
+	// Development version numbers look like "1.22-0dcbb4a001"; release
+	// version numbers look like "1.21.4". Either way, only major.minor
+	// matters for a MinVersion check.
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		s = s[:i]
+	}
+	parts := strings.SplitN(s, ".", 3)
+	if len(parts) < 2 {
+		return GoVersion{}, devel, fmt.Errorf("unrecognized go version number: %q", v)
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return GoVersion{}, devel, fmt.Errorf("unrecognized go major version: %q", v)
+	}
+	// Pre-release minor versions look like "22rc1" or "22beta1"; only the
+	// leading digits matter for a MinVersion comparison.
+	end := 0
+	for end < len(parts[1]) && parts[1][end] >= '0' && parts[1][end] <= '9' {
+		end++
+	}
+	minor, err := strconv.Atoi(parts[1][:end])
+	if err != nil {
+		return GoVersion{}, devel, fmt.Errorf("unrecognized go minor version: %q", v)
+	}
+	return GoVersion{Major: major, Minor: minor}, devel, nil
+}
+
+// CheckVersion verifies that the Go toolchain in this Environ is at least
+// c.MinVersion, returning a descriptive error if it is too old. A zero
+// MinVersion always passes, as does any development toolchain (e.g. "devel
+// go1.22-abcdef" or "gotip"), since those are newer than any released
+// version by definition.
+func (c Environ) CheckVersion() error {
+	if c.MinVersion == (GoVersion{}) {
+		return nil
+	}
+	v, err := c.Version()
+	if err != nil {
+		return fmt.Errorf("could not determine go version: %v", err)
+	}
+	got, devel, err := parseGoVersion(v)
+	if err != nil {
+		return fmt.Errorf("could not parse go version %q: %v", v, err)
+	}
+	return checkVersion(got, devel, c.MinVersion)
+}
+
+// checkVersion is CheckVersion's implementation, taking an already-parsed
+// version so callers that also need the parsed version elsewhere (such as
+// BuildDir, for buildFlags) don't have to shell out to `go version` twice.
+func checkVersion(got GoVersion, devel bool, min GoVersion) error {
+	if min == (GoVersion{}) {
+		return nil
+	}
+	if !devel && got.Less(min) {
+		return fmt.Errorf("go toolchain %s is too old, need >= %s", got, min)
+	}
+	return nil
 }
 
 // Default is the default build environment comprised of the default GOPATH,
@@ -48,6 +213,11 @@ func (c Environ) Version() (string, error) {
 	if len(s) < 3 {
 		return "", fmt.Errorf("unknown go version, tool returned weird output for 'go version': %v", string(v))
 	}
+	if s[2] == "devel" && len(s) >= 4 {
+		// E.g. "go version devel go1.22-0dcbb4a001 Wed Nov 8 ..." becomes
+		// "devel go1.22-0dcbb4a001".
+		return fmt.Sprintf("%s %s", s[2], s[3]), nil
+	}
 	return s[2], nil
 }
 
@@ -63,6 +233,12 @@ func (c Environ) Env() []string {
 	if c.GOPATH != "" {
 		env = append(env, fmt.Sprintf("GOPATH=%s", c.GOPATH))
 	}
+	if c.GOARM != "" {
+		env = append(env, fmt.Sprintf("GOARM=%s", c.GOARM))
+	}
+	if c.GOMIPS != "" {
+		env = append(env, fmt.Sprintf("GOMIPS=%s", c.GOMIPS))
+	}
 	var cgo int8
 	if c.CgoEnabled {
 		cgo = 1
@@ -92,11 +268,200 @@ type BuildOpts struct {
 	NoStrip bool
 	// ExtraArgs to `go build`.
 	ExtraArgs []string
+
+	// Hermetic, when set, builds the package offline and reproducibly: it
+	// forces GOPROXY=off and GOFLAGS=-mod=vendor in the build environment,
+	// and requires that dirPath (or its enclosing module) already vendors
+	// all of its dependencies. This is what lets gobusybox users produce a
+	// verifiable binary from nothing but a vendored source tarball on an
+	// air-gapped machine, the same guarantee restic's build.go gives.
+	Hermetic bool
+
+	// CopyToTmp, when combined with Hermetic, copies the directory holding
+	// the vendor/ directory (dirPath or the ancestor found for Hermetic) to
+	// a fresh temporary directory and invokes `go build` on dirPath's copy
+	// there, so that no path under the original tree leaks into the
+	// resulting binary's debug info.
+	CopyToTmp bool
+
+	// TrimPath controls whether build paths are trimmed out of the
+	// resulting binary's debugging information: -trimpath on Go >= 1.13,
+	// or the -gcflags/-asmflags -trimpath= equivalent on older toolchains.
+	// Nil defaults to true.
+	TrimPath *bool
+
+	// BuildVCS controls the -buildvcs flag on Go >= 1.18. Nil lets the
+	// toolchain decide; set to false to pass -buildvcs=false and keep
+	// builds reproducible when dirPath sits inside a .git checkout.
+	BuildVCS *bool
+
+	// PGOProfile, if set, is passed as -pgo=<path> on Go >= 1.21 to enable
+	// profile-guided optimization.
+	PGOProfile string
+
+	// CoverMode, if set, instruments the build for coverage, passing
+	// -cover -covermode=<CoverMode> and, if CoverPkg is also set,
+	// -coverpkg=<CoverPkg>.
+	CoverMode string
+	CoverPkg  []string
+}
+
+// buildFlags returns the `go build` flags implied by opts for toolchain
+// version v, replacing the old hard-coded
+// strings.Contains(version, "go1.13") sniffing with a numeric version
+// comparison.
+func buildFlags(v GoVersion, devel bool, gopath string, opts BuildOpts) []string {
+	atLeast := func(major, minor int) bool {
+		return devel || !v.Less(GoVersion{Major: major, Minor: minor})
+	}
+
+	var args []string
+
+	trimPath := true
+	if opts.TrimPath != nil {
+		trimPath = *opts.TrimPath
+	}
+	if trimPath {
+		// Reproducible builds: trim any GOPATHs out of the executable's
+		// debugging information.
+		//
+		// E.g. Trim /tmp/bb-*/ from /tmp/bb-12345567/src/github.com/...
+		if atLeast(1, 13) {
+			args = append(args, "-trimpath")
+		} else {
+			args = append(args, "-gcflags", fmt.Sprintf("-trimpath=%s", gopath))
+			args = append(args, "-asmflags", fmt.Sprintf("-trimpath=%s", gopath))
+		}
+	}
+
+	if opts.BuildVCS != nil && atLeast(1, 18) {
+		args = append(args, fmt.Sprintf("-buildvcs=%t", *opts.BuildVCS))
+	}
+
+	if opts.PGOProfile != "" && atLeast(1, 21) {
+		args = append(args, fmt.Sprintf("-pgo=%s", opts.PGOProfile))
+	}
+
+	if opts.CoverMode != "" {
+		args = append(args, "-cover", fmt.Sprintf("-covermode=%s", opts.CoverMode))
+		if len(opts.CoverPkg) > 0 {
+			args = append(args, fmt.Sprintf("-coverpkg=%s", strings.Join(opts.CoverPkg, ",")))
+		}
+	}
+
+	return args
+}
+
+// findVendoredDir returns the directory -- either dirPath itself or one of
+// its ancestors up to and including the enclosing Go module's root -- that
+// contains a vendor/ directory, for use by hermetic builds.
+func findVendoredDir(dirPath string) (string, error) {
+	dir, err := filepath.Abs(dirPath)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve %q: %v", dirPath, err)
+	}
+	for {
+		if fi, err := os.Stat(filepath.Join(dir, "vendor")); err == nil && fi.IsDir() {
+			return dir, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		isModuleRoot := false
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			isModuleRoot = true
+		}
+		dir = parent
+		if isModuleRoot {
+			break
+		}
+	}
+	return "", fmt.Errorf("hermetic build requires a vendor/ directory in %q or its enclosing module, but none was found", dirPath)
+}
+
+// copyTree recursively copies src to dst, preserving file modes.
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode())
+		}
+
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+
+		_, err = io.Copy(out, in)
+		return err
+	})
 }
 
 // BuildDir compiles the package in the directory `dirPath`, writing the build
 // object to `binaryPath`.
 func (c Environ) BuildDir(dirPath string, binaryPath string, opts BuildOpts) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	v, err := c.Version()
+	if err != nil {
+		return err
+	}
+	parsed, devel, err := parseGoVersion(v)
+	if err != nil {
+		return fmt.Errorf("could not parse go version %q: %v", v, err)
+	}
+	if err := checkVersion(parsed, devel, c.MinVersion); err != nil {
+		return err
+	}
+
+	if opts.Hermetic {
+		root, err := findVendoredDir(dirPath)
+		if err != nil {
+			return err
+		}
+		if opts.CopyToTmp {
+			// Copy the whole vendored root, not just dirPath, so that the
+			// copy still has a vendor/ directory to build against.
+			absDir, err := filepath.Abs(dirPath)
+			if err != nil {
+				return fmt.Errorf("could not resolve %q: %v", dirPath, err)
+			}
+			rel, err := filepath.Rel(root, absDir)
+			if err != nil {
+				return fmt.Errorf("could not resolve %q relative to vendored root %q: %v", dirPath, root, err)
+			}
+
+			tmp, err := os.MkdirTemp("", "bb-hermetic-")
+			if err != nil {
+				return fmt.Errorf("could not create hermetic build dir: %v", err)
+			}
+			defer os.RemoveAll(tmp)
+
+			if err := copyTree(root, tmp); err != nil {
+				return fmt.Errorf("could not copy %q to hermetic build dir: %v", root, err)
+			}
+			dirPath = filepath.Join(tmp, rel)
+		}
+	}
+
 	args := []string{
 		"build",
 
@@ -115,21 +480,7 @@ func (c Environ) BuildDir(dirPath string, binaryPath string, opts BuildOpts) err
 		args = append(args, `-ldflags=-s -w`) // Strip all symbols.
 	}
 
-	v, err := c.Version()
-	if err != nil {
-		return err
-	}
-
-	// Reproducible builds: Trim any GOPATHs out of the executable's
-	// debugging information.
-	//
-	// E.g. Trim /tmp/bb-*/ from /tmp/bb-12345567/src/github.com/...
-	if strings.Contains(v, "go1.13") || strings.Contains(v, "go1.14") || strings.Contains(v, "gotip") {
-		args = append(args, "-trimpath")
-	} else {
-		args = append(args, "-gcflags", fmt.Sprintf("-trimpath=%s", c.GOPATH))
-		args = append(args, "-asmflags", fmt.Sprintf("-trimpath=%s", c.GOPATH))
-	}
+	args = append(args, buildFlags(parsed, devel, c.GOPATH, opts)...)
 
 	if len(c.BuildTags) > 0 {
 		args = append(args, []string{"-tags", strings.Join(c.BuildTags, " ")}...)
@@ -139,9 +490,86 @@ func (c Environ) BuildDir(dirPath string, binaryPath string, opts BuildOpts) err
 
 	cmd := c.GoCmd(args...)
 	cmd.Dir = dirPath
+	if opts.Hermetic {
+		// Force a reproducible, offline build: never hit the network, and
+		// build strictly from the vendor/ directory we verified above.
+		cmd.Env = append(cmd.Env, "GOPROXY=off", "GOFLAGS=-mod=vendor")
+	}
 
 	if o, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("error building go package in %q: %v, %v", dirPath, string(o), err)
 	}
 	return nil
+}
+
+// Target is one GOOS/GOARCH (plus GOARM/GOMIPS, where applicable) variant
+// for BuildMatrix to build, and the binary path to write the result to.
+type Target struct {
+	GOOS   string
+	GOARCH string
+	GOARM  string
+	GOMIPS string
+
+	// Out is the path the resulting binary is written to.
+	Out string
+}
+
+// MatrixOpts are optional arguments to Environ.BuildMatrix.
+type MatrixOpts struct {
+	// BuildOpts are passed through to every per-target build.
+	BuildOpts
+
+	// Concurrency caps how many targets build at once. <= 0 means build all
+	// targets at once.
+	Concurrency int
+}
+
+// BuildMatrix builds the package in dirPath once per Target in targets,
+// concurrently, returning a map of each Target to the error (nil on
+// success) from building it.
+//
+// Each target builds against its own Environ, cloned from c with
+// GOOS/GOARCH/GOARM/GOMIPS overridden, so callers don't have to juggle
+// copies of build.Context themselves. Every clone shares c's GOCACHE, but
+// that needs no external locking: the Go build cache has supported
+// concurrent access from multiple `go` processes since Go 1.10. Concurrency
+// caps how many targets build at once.
+func (c Environ) BuildMatrix(dirPath string, targets []Target, opts MatrixOpts) map[Target]error {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 || concurrency > len(targets) {
+		concurrency = len(targets)
+	}
+
+	var (
+		sem   = make(chan struct{}, concurrency)
+		wg    sync.WaitGroup
+		resMu sync.Mutex
+	)
+	errs := make(map[Target]error, len(targets))
+	for _, t := range targets {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(t Target) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			tc := c
+			tc.GOOS = t.GOOS
+			tc.GOARCH = t.GOARCH
+			if t.GOARM != "" {
+				tc.GOARM = t.GOARM
+			}
+			if t.GOMIPS != "" {
+				tc.GOMIPS = t.GOMIPS
+			}
+
+			err := tc.BuildDir(dirPath, t.Out, opts.BuildOpts)
+
+			resMu.Lock()
+			errs[t] = err
+			resMu.Unlock()
+		}(t)
+	}
+	wg.Wait()
+	return errs
 }
\ No newline at end of file
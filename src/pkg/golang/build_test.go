@@ -0,0 +1,262 @@
+// Copyright 2015-2018 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package golang
+
+import (
+	"go/build"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func boolPtr(b bool) *bool { return &b }
+
+func TestParseGoVersion(t *testing.T) {
+	for _, tt := range []struct {
+		in      string
+		want    GoVersion
+		devel   bool
+		wantErr bool
+	}{
+		{in: "go1.21.4", want: GoVersion{Major: 1, Minor: 21}},
+		{in: "go1.13", want: GoVersion{Major: 1, Minor: 13}},
+		{in: "go1.22rc1", want: GoVersion{Major: 1, Minor: 22}},
+		{in: "go1.22beta1", want: GoVersion{Major: 1, Minor: 22}},
+		{in: "devel go1.22-0dcbb4a001", want: GoVersion{Major: 1, Minor: 22}, devel: true},
+		{in: "gotip", want: GoVersion{}, devel: true},
+		{in: "1.21.4", wantErr: true},
+		{in: "go1", wantErr: true},
+		{in: "gojunk", wantErr: true},
+	} {
+		got, devel, err := parseGoVersion(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseGoVersion(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseGoVersion(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+		if devel != tt.devel {
+			t.Errorf("parseGoVersion(%q) devel = %v, want %v", tt.in, devel, tt.devel)
+		}
+	}
+}
+
+func TestBuildDirHermetic(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not available")
+	}
+
+	t.Run("missing vendor dir is an error", func(t *testing.T) {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		c := Default()
+		err := c.BuildDir(dir, filepath.Join(t.TempDir(), "out"), BuildOpts{Hermetic: true})
+		if err == nil {
+			t.Fatal("BuildDir() with Hermetic set and no vendor/ dir: got nil error, want one")
+		}
+	})
+
+	t.Run("vendor found in module root, CopyToTmp rebases correctly", func(t *testing.T) {
+		root := t.TempDir()
+		pkgDir := filepath.Join(root, "cmd", "foo")
+		if err := os.MkdirAll(pkgDir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Join(root, "vendor"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		// go 1.13 so the vendor consistency check (which requires
+		// vendor/modules.txt on newer `go` directives) doesn't kick in for
+		// this dependency-free module.
+		if err := os.WriteFile(filepath.Join(root, "go.mod"), []byte("module example.com/foo\n\ngo 1.13\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(pkgDir, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		c := Default()
+		c.GO111MODULE = "on"
+		c.CgoEnabled = false
+
+		out := filepath.Join(t.TempDir(), "foo")
+		if err := c.BuildDir(pkgDir, out, BuildOpts{Hermetic: true, CopyToTmp: true}); err != nil {
+			t.Fatalf("BuildDir() with Hermetic+CopyToTmp: %v", err)
+		}
+		if _, err := os.Stat(out); err != nil {
+			t.Errorf("binary not written: %v", err)
+		}
+	})
+}
+
+func TestBuildMatrix(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go toolchain not available")
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte("package main\n\nfunc main() {}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c := Default()
+	c.GO111MODULE = "off"
+	c.CgoEnabled = false
+
+	outDir := t.TempDir()
+	targets := []Target{
+		{GOOS: "linux", GOARCH: "amd64", Out: filepath.Join(outDir, "linux_amd64")},
+		{GOOS: "linux", GOARCH: "arm64", Out: filepath.Join(outDir, "linux_arm64")},
+		{GOOS: "multics", GOARCH: "amd64", Out: filepath.Join(outDir, "bogus")},
+	}
+
+	errs := c.BuildMatrix(dir, targets, MatrixOpts{Concurrency: 2})
+	if len(errs) != len(targets) {
+		t.Fatalf("BuildMatrix() returned %d results, want %d (one per target)", len(errs), len(targets))
+	}
+
+	for _, target := range targets[:2] {
+		if err := errs[target]; err != nil {
+			t.Errorf("target %+v: unexpected error: %v", target, err)
+		}
+		if _, err := os.Stat(target.Out); err != nil {
+			t.Errorf("target %+v: binary not written: %v", target, err)
+		}
+	}
+
+	bogus := targets[2]
+	if err := errs[bogus]; err == nil {
+		t.Errorf("target %+v: expected an error for the unsupported GOOS, got nil", bogus)
+	}
+	if _, err := os.Stat(bogus.Out); err == nil {
+		t.Errorf("target %+v: expected no binary to be written", bogus)
+	}
+}
+
+func TestBuildFlags(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		v      GoVersion
+		devel  bool
+		gopath string
+		opts   BuildOpts
+		want   []string
+	}{
+		{
+			name:   "pre-1.13 trimpath falls back to gcflags/asmflags",
+			v:      GoVersion{Major: 1, Minor: 12},
+			gopath: "/gopath",
+			want:   []string{"-gcflags", "-trimpath=/gopath", "-asmflags", "-trimpath=/gopath"},
+		},
+		{
+			name: "1.13 gets -trimpath",
+			v:    GoVersion{Major: 1, Minor: 13},
+			want: []string{"-trimpath"},
+		},
+		{
+			name: "TrimPath: false disables trimming",
+			v:    GoVersion{Major: 1, Minor: 21},
+			opts: BuildOpts{TrimPath: boolPtr(false)},
+		},
+		{
+			name: "pre-1.18 ignores BuildVCS",
+			v:    GoVersion{Major: 1, Minor: 17},
+			opts: BuildOpts{TrimPath: boolPtr(false), BuildVCS: boolPtr(false)},
+		},
+		{
+			name: "1.18 emits -buildvcs=false",
+			v:    GoVersion{Major: 1, Minor: 18},
+			opts: BuildOpts{TrimPath: boolPtr(false), BuildVCS: boolPtr(false)},
+			want: []string{"-buildvcs=false"},
+		},
+		{
+			name: "pre-1.21 ignores PGOProfile",
+			v:    GoVersion{Major: 1, Minor: 20},
+			opts: BuildOpts{TrimPath: boolPtr(false), PGOProfile: "cpu.pprof"},
+		},
+		{
+			name: "1.21 emits -pgo",
+			v:    GoVersion{Major: 1, Minor: 21},
+			opts: BuildOpts{TrimPath: boolPtr(false), PGOProfile: "cpu.pprof"},
+			want: []string{"-pgo=cpu.pprof"},
+		},
+		{
+			name:  "devel toolchain always satisfies version gates",
+			v:     GoVersion{},
+			devel: true,
+			opts:  BuildOpts{BuildVCS: boolPtr(false), PGOProfile: "cpu.pprof"},
+			want:  []string{"-trimpath", "-buildvcs=false", "-pgo=cpu.pprof"},
+		},
+		{
+			name: "coverage flags",
+			v:    GoVersion{Major: 1, Minor: 21},
+			opts: BuildOpts{TrimPath: boolPtr(false), CoverMode: "atomic", CoverPkg: []string{"./...", "example.com/foo"}},
+			want: []string{"-cover", "-covermode=atomic", "-coverpkg=./...,example.com/foo"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got := buildFlags(tt.v, tt.devel, tt.gopath, tt.opts)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("buildFlags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		goos    string
+		goarch  string
+		wantErr bool
+	}{
+		{name: "empty GOOS/GOARCH"},
+		{name: "valid pair", goos: "linux", goarch: "amd64"},
+		{name: "unknown GOARCH", goarch: "vax", wantErr: true},
+		{name: "unknown GOOS", goos: "multics", wantErr: true},
+		{name: "windows/loong64 is an invalid pair", goos: "windows", goarch: "loong64", wantErr: true},
+		{name: "plan9/arm64 is an invalid pair", goos: "plan9", goarch: "arm64", wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Environ{Context: build.Context{GOOS: tt.goos, GOARCH: tt.goarch}}
+			err := c.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() with GOOS=%q GOARCH=%q: error = %v, wantErr %v", tt.goos, tt.goarch, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckVersion(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		got     GoVersion
+		devel   bool
+		min     GoVersion
+		wantErr bool
+	}{
+		{name: "no minimum set", got: GoVersion{Major: 1, Minor: 10}, min: GoVersion{}},
+		{name: "meets minimum", got: GoVersion{Major: 1, Minor: 21}, min: GoVersion{Major: 1, Minor: 21}},
+		{name: "newer major", got: GoVersion{Major: 2, Minor: 0}, min: GoVersion{Major: 1, Minor: 21}},
+		{name: "too old", got: GoVersion{Major: 1, Minor: 12}, min: GoVersion{Major: 1, Minor: 13}, wantErr: true},
+		{name: "devel toolchain always passes", got: GoVersion{}, devel: true, min: GoVersion{Major: 1, Minor: 99}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkVersion(tt.got, tt.devel, tt.min)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkVersion(%v, %v, %v) error = %v, wantErr %v", tt.got, tt.devel, tt.min, err, tt.wantErr)
+			}
+		})
+	}
+}